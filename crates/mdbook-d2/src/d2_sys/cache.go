@@ -0,0 +1,286 @@
+package main
+
+import "C"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+// cacheLRUEntries bounds the in-memory front layer so a long mdbook build
+// doesn't keep every rendered board resident; entries evicted from it are
+// still on disk under Cache.dir and get reloaded into the LRU on their next
+// hit.
+const cacheLRUEntries = 128
+
+// Cache is a persistent, content-hash-keyed store of RenderResults, backed
+// by a directory on disk and fronted by an in-memory LRU so repeat renders
+// within one process don't even touch the filesystem. It's safe for
+// concurrent use.
+type Cache struct {
+	dir string
+
+	mu           sync.Mutex
+	lru          *lru.Cache
+	hits, misses uint64
+}
+
+// NewCache opens a persistent render cache rooted at dir. dir is created
+// lazily on the first write rather than here, so opening a cache that's
+// never written to never touches the filesystem.
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+		lru: lru.New(cacheLRUEntries),
+	}
+}
+
+// cacheKey hashes the inputs that determine a render's output: the D2
+// source, the requested layout engine and theme, and the D2 library version
+// (so upgrading D2 invalidates old entries instead of serving stale SVGs).
+func cacheKey(content string, layout string, themeID int64) string {
+	h := sha256.New()
+	io.WriteString(h, content)
+	io.WriteString(h, "\x00")
+	io.WriteString(h, layout)
+	io.WriteString(h, "\x00")
+	fmt.Fprintf(h, "%d", themeID)
+	io.WriteString(h, "\x00")
+	io.WriteString(h, version.Version)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get looks up key, checking the in-memory LRU before falling back to dir
+// on disk.
+func (c *Cache) get(key string) (*RenderResult, bool) {
+	c.mu.Lock()
+	if v, ok := c.lru.Get(key); ok {
+		c.hits++
+		c.mu.Unlock()
+		result := *v.(*RenderResult)
+		return &result, true
+	}
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	var result RenderResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.hits++
+	c.lru.Add(key, &result)
+	c.mu.Unlock()
+	return &result, true
+}
+
+// put stores result under key, both in the in-memory LRU and on disk.
+// The on-disk write goes through a temp file + os.Rename so a reader never
+// observes a partially written entry, even if two RenderCached calls race
+// on the same key.
+func (c *Cache) put(key string, result *RenderResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "tmp-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.lru.Add(key, result)
+	c.mu.Unlock()
+	return nil
+}
+
+// evict removes every entry whose cache file hasn't been written to in
+// olderThan, returning how many it removed.
+func (c *Cache) evict(olderThan time.Duration) (int, error) {
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	evicted := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+
+		key := strings.TrimSuffix(entry.Name(), ".json")
+		if err := os.Remove(c.path(key)); err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		c.lru.Remove(key)
+		c.mu.Unlock()
+		evicted++
+	}
+
+	return evicted, nil
+}
+
+// cacheRegistry hands out opaque handles for OpenCache's *Cache values. A
+// Go pointer can't be passed across the FFI boundary and kept alive by the
+// caller (cgo's pointer-passing rules forbid it), so RenderCached/CacheStats
+// /CacheEvict address a Cache by this handle instead.
+var (
+	cacheRegistryMu sync.Mutex
+	cacheRegistry   = map[uintptr]*Cache{}
+	nextCacheHandle uintptr
+)
+
+//export OpenCache
+func OpenCache(dir string) uintptr {
+	cacheRegistryMu.Lock()
+	defer cacheRegistryMu.Unlock()
+
+	nextCacheHandle++
+	cacheRegistry[nextCacheHandle] = NewCache(dir)
+	return nextCacheHandle
+}
+
+func cacheByHandle(handle uintptr) (*Cache, error) {
+	cacheRegistryMu.Lock()
+	defer cacheRegistryMu.Unlock()
+
+	cache, ok := cacheRegistry[handle]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache handle %d", handle)
+	}
+	return cache, nil
+}
+
+//export RenderCached
+func RenderCached(cacheHandle uintptr, content string) *C.char {
+	return serializeResult(renderCached(cacheHandle, content))
+}
+
+func renderCached(handle uintptr, content string) (*RenderResult, error) {
+	cache, err := cacheByHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := RenderOptions{Content: content}
+	var themeID int64
+	if opts.ThemeID != nil {
+		themeID = *opts.ThemeID
+	}
+	key := cacheKey(content, opts.Layout, themeID)
+
+	if result, ok := cache.get(key); ok {
+		return result, nil
+	}
+
+	result, err := render(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.put(key, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CacheStatsResult is the response for CacheStats.
+type CacheStatsResult struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+//export CacheStats
+func CacheStats(cacheHandle uintptr) *C.char {
+	return serializeResult(cacheStats(cacheHandle))
+}
+
+func cacheStats(handle uintptr) (*CacheStatsResult, error) {
+	cache, err := cacheByHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return &CacheStatsResult{Hits: cache.hits, Misses: cache.misses}, nil
+}
+
+// CacheEvictResult is the response for CacheEvict.
+type CacheEvictResult struct {
+	Evicted int `json:"evicted"`
+}
+
+//export CacheEvict
+func CacheEvict(cacheHandle uintptr, olderThanSeconds int64) *C.char {
+	return serializeResult(cacheEvict(cacheHandle, olderThanSeconds))
+}
+
+func cacheEvict(handle uintptr, olderThanSeconds int64) (*CacheEvictResult, error) {
+	cache, err := cacheByHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	evicted, err := cache.evict(time.Duration(olderThanSeconds) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CacheEvictResult{Evicted: evicted}, nil
+}
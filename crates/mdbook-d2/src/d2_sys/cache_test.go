@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const cacheTestDiagram = `x -> y`
+
+func TestRenderCached(t *testing.T) {
+	dir := t.TempDir()
+	handle := OpenCache(dir)
+
+	result, err := renderCached(handle, cacheTestDiagram)
+	if err != nil {
+		t.Fatalf("renderCached failed: %v", err)
+	}
+	if result.Content == "" {
+		t.Fatal("expected non-empty content")
+	}
+
+	stats, err := cacheStats(handle)
+	if err != nil {
+		t.Fatalf("cacheStats failed: %v", err)
+	}
+	if stats.Misses != 1 || stats.Hits != 0 {
+		t.Errorf("expected 1 miss and 0 hits after first render, got %+v", stats)
+	}
+
+	if _, err := renderCached(handle, cacheTestDiagram); err != nil {
+		t.Fatalf("renderCached (second call) failed: %v", err)
+	}
+	stats, err = cacheStats(handle)
+	if err != nil {
+		t.Fatalf("cacheStats failed: %v", err)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("expected 1 hit after a repeat render, got %+v", stats)
+	}
+}
+
+func TestRenderCachedUnknownHandle(t *testing.T) {
+	if _, err := renderCached(999999, cacheTestDiagram); err == nil {
+		t.Error("expected an error for an unknown cache handle")
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(dir)
+
+	key := cacheKey(cacheTestDiagram, "", 0)
+	if err := cache.put(key, &RenderResult{Content: "<svg/>"}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	if _, ok := cache.get(key); !ok {
+		t.Fatal("expected a hit immediately after put")
+	}
+
+	evicted, err := cache.evict(0)
+	if err != nil {
+		t.Fatalf("evict failed: %v", err)
+	}
+	if evicted != 1 {
+		t.Errorf("expected 1 entry evicted, got %d", evicted)
+	}
+
+	if _, ok := cache.get(key); ok {
+		t.Error("expected a miss after eviction")
+	}
+}
+
+func TestCacheEvictKeepsRecentEntries(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(dir)
+
+	key := cacheKey(cacheTestDiagram, "", 0)
+	if err := cache.put(key, &RenderResult{Content: "<svg/>"}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	evicted, err := cache.evict(time.Hour)
+	if err != nil {
+		t.Fatalf("evict failed: %v", err)
+	}
+	if evicted != 0 {
+		t.Errorf("expected a freshly written entry to survive, got %d evicted", evicted)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import "C"
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cdr.dev/slog"
+)
+
+// diagnosticsRingSize bounds how many LogEntry values a single render keeps,
+// so a pathological diagram (e.g. thousands of unresolved image refs) can't
+// grow RenderResult without bound.
+const diagnosticsRingSize = 200
+
+// LogEntry is one structured diagnostic captured while compiling, laying out
+// or rendering a diagram, surfaced via RenderResult.Diagnostics instead of
+// being silently discarded.
+type LogEntry struct {
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Board   string         `json:"board,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// diagnosticsSink is a slog.Sink that records entries into an in-memory ring
+// buffer rather than writing them anywhere. render creates one fresh per
+// call (see render, renderPDF) so concurrent invocations across the FFI
+// boundary never share, or contend over, a sink.
+type diagnosticsSink struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+func newDiagnosticsSink() *diagnosticsSink {
+	return &diagnosticsSink{}
+}
+
+func (s *diagnosticsSink) LogEntry(_ context.Context, e slog.SinkEntry) {
+	entry := LogEntry{
+		Level:   e.Level.String(),
+		Message: e.Message,
+		Fields:  make(map[string]any, len(e.Fields)),
+	}
+	for _, f := range e.Fields {
+		if f.Name == "board" {
+			if board, ok := f.Value.(string); ok {
+				entry.Board = board
+				continue
+			}
+		}
+		entry.Fields[f.Name] = f.Value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= diagnosticsRingSize {
+		s.entries = s.entries[1:]
+	}
+	s.entries = append(s.entries, entry)
+}
+
+func (s *diagnosticsSink) Sync() {}
+
+func (s *diagnosticsSink) snapshot() []LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]LogEntry, len(s.entries))
+	copy(out, s.entries)
+	return out
+}
+
+// logLevel is the level new diagnosticsSink loggers are created at. It's set
+// process-wide via SetLogLevel (mdbook plugins read it once from book.toml),
+// but the sink each render logs into is still created fresh per call, so
+// concurrent renders never share captured entries.
+var (
+	logLevelMu sync.RWMutex
+	logLevel   = slog.LevelInfo
+)
+
+func currentLogLevel() slog.Level {
+	logLevelMu.RLock()
+	defer logLevelMu.RUnlock()
+	return logLevel
+}
+
+//export SetLogLevel
+func SetLogLevel(level string) *C.char {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return serializeError(err)
+	}
+
+	logLevelMu.Lock()
+	logLevel = lvl
+	logLevelMu.Unlock()
+
+	return C.CString("")
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unsupported log level %q", level)
+	}
+}
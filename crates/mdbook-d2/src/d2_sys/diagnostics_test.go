@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"cdr.dev/slog"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	if lvl, err := parseLogLevel("debug"); err != nil || lvl != slog.LevelDebug {
+		t.Errorf("expected debug to parse to LevelDebug, got %v, %v", lvl, err)
+	}
+	if _, err := parseLogLevel("bogus"); err == nil {
+		t.Error("expected an error for an unsupported log level")
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	defer func() { logLevel = slog.LevelInfo }()
+
+	SetLogLevel("debug")
+	if currentLogLevel() != slog.LevelDebug {
+		t.Errorf("expected level LevelDebug, got %v", currentLogLevel())
+	}
+}
+
+func TestDiagnosticsRingBuffer(t *testing.T) {
+	sink := newDiagnosticsSink()
+	for i := 0; i < diagnosticsRingSize+10; i++ {
+		sink.LogEntry(nil, slog.SinkEntry{Message: "overflow", Fields: slog.M(slog.F("board", "root"))})
+	}
+
+	entries := sink.snapshot()
+	if len(entries) != diagnosticsRingSize {
+		t.Errorf("expected ring buffer capped at %d entries, got %d", diagnosticsRingSize, len(entries))
+	}
+	if entries[0].Board != "root" {
+		t.Errorf("expected board field to be lifted onto LogEntry.Board, got %q", entries[0].Board)
+	}
+	if strings.Contains(entries[0].Message, "err:") {
+		t.Errorf("unexpected error prefix in message: %q", entries[0].Message)
+	}
+}
@@ -3,16 +3,20 @@ package main
 import "C"
 import (
 	"cdr.dev/slog"
-	"cdr.dev/slog/sloggers/sloghuman"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
+	"io/fs"
+	"os"
 	"oss.terrastruct.com/d2/d2compiler"
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2layouts/d2elklayout"
 	"oss.terrastruct.com/d2/d2lib"
 	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2plugin"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/imgbundler"
@@ -28,6 +32,16 @@ type RenderResult struct {
 	Name         string `json:"name"`
 	IsFolderOnly bool   `json:"isFolderOnly"`
 	Content      string `json:"content"`
+	// ContentType is the MIME type of Content: "image/svg+xml" for Render and
+	// RenderWithOptions, or "image/png" for RenderPNG. Content is base64 encoded
+	// whenever ContentType isn't "image/svg+xml".
+	ContentType string `json:"contentType"`
+
+	// Diagnostics holds the structured log entries D2 produced while
+	// compiling, laying out and rendering this board (layout warnings,
+	// imgbundler fetch failures, and so on). Only set on the result returned
+	// directly by render, not on nested Layers/Scenarios/Steps entries.
+	Diagnostics []LogEntry `json:"diagnostics,omitempty"`
 
 	Root *d2graph.Object `json:"root"`
 
@@ -36,34 +50,191 @@ type RenderResult struct {
 	Steps     []RenderResult `json:"steps"`
 }
 
+const (
+	ContentTypeSVG = "image/svg+xml"
+	ContentTypePNG = "image/png"
+	ContentTypePDF = "application/pdf"
+)
+
 //export Render
 func Render(content string) *C.char {
-	return serializeResult(render(content))
+	return serializeResult(render(content, RenderOptions{}))
+}
+
+//export RenderWithOptions
+func RenderWithOptions(optionsJSON string) *C.char {
+	var opts RenderOptions
+	if err := json.Unmarshal([]byte(optionsJSON), &opts); err != nil {
+		return serializeError(err)
+	}
+
+	return serializeResult(render(opts.Content, opts))
+}
+
+// RenderInDir mirrors Render, but resolves local image references (e.g.
+// `icon: ./diagrams/foo.png`) against baseDir instead of leaving them
+// unbundled. Remote (http/https) references still aren't fetched unless the
+// caller goes through RenderWithOptions with AllowRemote set.
+//
+//export RenderInDir
+func RenderInDir(content string, baseDir string) *C.char {
+	return serializeResult(render(content, RenderOptions{BaseDir: baseDir}))
 }
 
-func render(content string) (*RenderResult, error) {
-	ctx := log.With(context.Background(), slog.Make(sloghuman.Sink(io.Discard)))
+//export RenderPNG
+func RenderPNG(content string, scale float64) *C.char {
+	return serializeResult(renderPNG(content, scale))
+}
+
+func renderPNG(content string, scale float64) (*RenderResult, error) {
+	result, err := render(content, RenderOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return rasterizeResult(result, scale)
+}
+
+// rasterizeResult replaces the SVG held in each board's Content with a
+// base64-encoded PNG, recursing into Layers, Scenarios and Steps so every
+// board in the tree ends up rasterized the same way.
+func rasterizeResult(result *RenderResult, scale float64) (*RenderResult, error) {
+	png, err := svgToPNG([]byte(result.Content), scale)
+	if err != nil {
+		return nil, err
+	}
+
+	rasterized := *result
+	rasterized.Content = base64.StdEncoding.EncodeToString(png)
+	rasterized.ContentType = ContentTypePNG
+
+	rasterized.Layers, err = rasterizeResults(result.Layers, scale)
+	if err != nil {
+		return nil, err
+	}
+	rasterized.Scenarios, err = rasterizeResults(result.Scenarios, scale)
+	if err != nil {
+		return nil, err
+	}
+	rasterized.Steps, err = rasterizeResults(result.Steps, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rasterized, nil
+}
+
+func rasterizeResults(results []RenderResult, scale float64) ([]RenderResult, error) {
+	var rasterized []RenderResult
+	for _, result := range results {
+		r, err := rasterizeResult(&result, scale)
+		if err != nil {
+			return nil, err
+		}
+		rasterized = append(rasterized, *r)
+	}
+
+	return rasterized, nil
+}
+
+// RenderOptions is the request body accepted by RenderWithOptions. Content
+// holds the D2 source; the rest override what would otherwise be decided by
+// the document's own `vars: { d2-config: ... }` block. Any field left at its
+// zero value falls back to the document's d2-config vars, and finally to the
+// library defaults, so a document-level layout override still wins unless
+// the caller explicitly pins one here.
+type RenderOptions struct {
+	Content string `json:"content"`
+
+	// Layout selects the layout engine: "dagre" (default), "elk", or "tala".
+	Layout string `json:"layout,omitempty"`
+
+	ThemeID *int64 `json:"theme_id,omitempty"`
+	Sketch  *bool  `json:"sketch,omitempty"`
+	Pad     *int64 `json:"pad,omitempty"`
+
+	// BaseDir roots local image references (e.g. "./diagrams/foo.png") for
+	// resolution. It's rooted via os.DirFS, so a reference can't escape it
+	// with "../" segments. Left empty, local image references are left
+	// unresolved, same as before this field existed.
+	BaseDir string `json:"base_dir,omitempty"`
+
+	// AllowRemote opts into fetching http(s) image references over the
+	// network. Off by default, so Render/RenderWithOptions/RenderInDir
+	// callers don't make outbound requests unless they ask to.
+	AllowRemote bool `json:"allow_remote,omitempty"`
+}
+
+func render(content string, opts RenderOptions) (*RenderResult, error) {
+	sink := newDiagnosticsSink()
+	ctx := log.With(context.Background(), slog.Make(sink).Leveled(currentLogLevel()))
 	ruler, err := textmeasure.NewRuler()
 	if err != nil {
 		return nil, err
 	}
-	layoutResolver := func(engine string) (d2graph.LayoutGraph, error) {
-		return d2dagrelayout.DefaultLayout, nil
+
+	compileOpts := &d2lib.CompileOptions{
+		LayoutResolver: layoutResolver,
+		Ruler:          ruler,
+	}
+	if opts.Layout != "" {
+		compileOpts.Layout = &opts.Layout
+	}
+	renderOpts := &d2svg.RenderOpts{
+		ThemeID: opts.ThemeID,
+		Sketch:  opts.Sketch,
+		Pad:     opts.Pad,
+	}
+
+	diagram, graph, err := d2lib.Compile(ctx, content, compileOpts, renderOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var fsys fs.FS
+	if opts.BaseDir != "" {
+		fsys = os.DirFS(opts.BaseDir)
 	}
-	renderOpts := &d2svg.RenderOpts{}
 
-	diagram, graph, err := d2lib.Compile(ctx, content,
-		&d2lib.CompileOptions{
-			LayoutResolver: layoutResolver,
-			Ruler:          ruler,
-		},
-		renderOpts,
-	)
+	result, err := renderRecursive(diagram, graph, renderOpts, ctx, fsys, opts.AllowRemote)
 	if err != nil {
 		return nil, err
 	}
+	result.Diagnostics = sink.snapshot()
 
-	return renderRecursive(diagram, graph, renderOpts, ctx)
+	return result, nil
+}
+
+// layoutResolver dispatches a `layout` name (set explicitly via
+// RenderOptions or read from a document's d2-config vars) to a concrete
+// d2graph.LayoutGraph implementation.
+func layoutResolver(engine string) (d2graph.LayoutGraph, error) {
+	switch engine {
+	case "", "dagre":
+		return d2dagrelayout.DefaultLayout, nil
+	case "elk":
+		return d2elklayout.DefaultLayout, nil
+	case "tala":
+		return talaLayout, nil
+	default:
+		return nil, fmt.Errorf("unsupported layout engine %q", engine)
+	}
+}
+
+// talaLayout shells out to a d2plugin-tala binary on $PATH using D2's own
+// binary plugin protocol (see d2plugin.execPlugin), since TALA is a
+// proprietary layout engine that can't be vendored as a Go dependency.
+func talaLayout(ctx context.Context, g *d2graph.Graph) error {
+	plugins, err := d2plugin.ListPlugins(ctx)
+	if err != nil {
+		return err
+	}
+	plugin, err := d2plugin.FindPlugin(ctx, plugins, "tala")
+	if err != nil {
+		return errors.New("tala layout requested but no d2plugin-tala binary was found on $PATH")
+	}
+
+	return plugin.Layout(ctx, g)
 }
 
 func renderRecursive(
@@ -71,17 +242,21 @@ func renderRecursive(
 	graph *d2graph.Graph,
 	renderOpts *d2svg.RenderOpts,
 	ctx context.Context,
+	fsys fs.FS,
+	allowRemote bool,
 ) (*RenderResult, error) {
 	var layers []RenderResult
 	var scenarios []RenderResult
 	var steps []RenderResult
 
+	ctx = slog.With(ctx, slog.F("board", diagram.Name))
+
 	if len(diagram.Layers) != len(graph.Layers) {
 		return nil, errors.New("layers count mismatch")
 	}
 	for i, layer := range diagram.Layers {
 		layerGraph := graph.Layers[i]
-		layerResult, err := renderRecursive(layer, layerGraph, renderOpts, ctx)
+		layerResult, err := renderRecursive(layer, layerGraph, renderOpts, ctx, fsys, allowRemote)
 		if err != nil {
 			return nil, err
 		}
@@ -93,7 +268,7 @@ func renderRecursive(
 	}
 	for i, scenario := range diagram.Scenarios {
 		scenarioGraph := graph.Scenarios[i]
-		scenarioResult, err := renderRecursive(scenario, scenarioGraph, renderOpts, ctx)
+		scenarioResult, err := renderRecursive(scenario, scenarioGraph, renderOpts, ctx, fsys, allowRemote)
 		if err != nil {
 			return nil, err
 		}
@@ -105,7 +280,7 @@ func renderRecursive(
 	}
 	for i, step := range diagram.Steps {
 		stepGraph := graph.Steps[i]
-		stepResult, err := renderRecursive(step, stepGraph, renderOpts, ctx)
+		stepResult, err := renderRecursive(step, stepGraph, renderOpts, ctx, fsys, allowRemote)
 		if err != nil {
 			return nil, err
 		}
@@ -117,16 +292,22 @@ func renderRecursive(
 		return nil, err
 	}
 
-	// we don't have a filesystem setup to pull images from
-	svg, err = imgbundler.BundleRemote(ctx, simplelog.FromLibLog(ctx), svg, false)
-	if err != nil {
-		return nil, err
+	if fsys != nil {
+		svg = bundleLocal(ctx, fsys, svg)
+	}
+
+	if allowRemote {
+		svg, err = imgbundler.BundleRemote(ctx, simplelog.FromLibLog(ctx), svg, false)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &RenderResult{
 		Name:         diagram.Name,
 		IsFolderOnly: diagram.IsFolderOnly,
 		Content:      string(svg),
+		ContentType:  ContentTypeSVG,
 		Root:         graph.Root,
 		Layers:       layers,
 		Scenarios:    scenarios,
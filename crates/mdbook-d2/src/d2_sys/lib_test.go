@@ -26,10 +26,25 @@ steps: {
   3: {
     Cross road -> Make you wonder why
   }
-}`)
+}`, RenderOptions{})
 	if err != nil {
 		t.Errorf("Render failed: %v", err)
 	}
 
 	println(result)
 }
+
+func TestLayoutResolver(t *testing.T) {
+	if _, err := layoutResolver(""); err != nil {
+		t.Errorf("expected default layout to resolve, got: %v", err)
+	}
+	if _, err := layoutResolver("dagre"); err != nil {
+		t.Errorf("expected dagre layout to resolve, got: %v", err)
+	}
+	if _, err := layoutResolver("elk"); err != nil {
+		t.Errorf("expected elk layout to resolve, got: %v", err)
+	}
+	if _, err := layoutResolver("bogus"); err == nil {
+		t.Error("expected an error for an unsupported layout engine")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"cdr.dev/slog"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+var localImageRegex = regexp.MustCompile(`<image href="([^"]+)"`)
+
+// bundleLocal resolves local image references in svg against fsys, inlining
+// each as a base64 data URI. It mirrors imgbundler.BundleRemote's approach
+// (find <image href> elements, replace them with data URIs) but reads from a
+// fs.FS rather than fetching over HTTP, so references can't escape the
+// directory fsys is rooted at.
+//
+// References it can't resolve - remote URLs (left for imgbundler.BundleRemote
+// to handle when allowed), refs that escape fsys's root, missing files - are
+// left as-is in svg and reported via log.Warn instead of failing the render.
+func bundleLocal(ctx context.Context, fsys fs.FS, svg []byte) []byte {
+	seen := make(map[string]struct{})
+	for _, match := range localImageRegex.FindAllSubmatch(svg, -1) {
+		href := string(match[1])
+		if _, ok := seen[href]; ok {
+			continue
+		}
+		seen[href] = struct{}{}
+
+		if strings.HasPrefix(href, "data:") || isRemoteHref(href) {
+			continue
+		}
+
+		fsPath, ok := localFSPath(href)
+		if !ok {
+			log.Warn(ctx, "image reference escapes base dir, leaving unresolved", slog.F("href", href))
+			continue
+		}
+
+		buf, err := fs.ReadFile(fsys, fsPath)
+		if err != nil {
+			log.Warn(ctx, "could not read local image, leaving unresolved", slog.F("href", href), slog.Error(err))
+			continue
+		}
+
+		dataURI := fmt.Sprintf(`<image href="data:%s;base64,%s"`, sniffMimeType(fsPath, buf), base64.StdEncoding.EncodeToString(buf))
+		svg = bytes.Replace(svg, match[0], []byte(dataURI), -1)
+	}
+
+	return svg
+}
+
+// localFSPath converts an href found in a D2-generated SVG (a "file://" URI
+// or a path relative to BaseDir, e.g. "./diagrams/foo.png") into a path
+// fs.FS.Open will accept. It returns ok=false for anything fs.ValidPath
+// rejects, which includes any href whose "../" segments would walk it
+// outside of BaseDir.
+func localFSPath(href string) (string, bool) {
+	href = html.UnescapeString(href)
+	href = strings.TrimPrefix(href, "file://")
+	href = strings.TrimPrefix(href, "./")
+	href = path.Clean(href)
+
+	if !fs.ValidPath(href) {
+		return "", false
+	}
+	return href, true
+}
+
+func isRemoteHref(href string) bool {
+	u, err := url.Parse(html.UnescapeString(href))
+	return err == nil && strings.HasPrefix(u.Scheme, "http")
+}
+
+// sniffMimeType mirrors imgbundler's unexported sniffMimeType: guess by file
+// extension first, falling back to content sniffing.
+func sniffMimeType(p string, buf []byte) string {
+	mimeType := mime.TypeByExtension(path.Ext(p))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(buf)
+	}
+	return strings.Replace(mimeType, "text/xml", "image/svg+xml", 1)
+}
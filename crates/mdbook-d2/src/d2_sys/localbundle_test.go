@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"cdr.dev/slog"
+	"cdr.dev/slog/sloggers/sloghuman"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func testContext() context.Context {
+	return log.With(context.Background(), slog.Make(sloghuman.Sink(io.Discard)))
+}
+
+func TestBundleLocal(t *testing.T) {
+	fsys := fstest.MapFS{
+		"icon.png": &fstest.MapFile{Data: []byte("not a real png, just bytes")},
+	}
+
+	svg := []byte(`<g><image href="./icon.png" x="0" y="0" /></g>`)
+	out := bundleLocal(testContext(), fsys, svg)
+
+	if strings.Contains(string(out), "./icon.png") {
+		t.Error("expected local href to be inlined as a data URI")
+	}
+	if !strings.Contains(string(out), "data:") {
+		t.Error("expected a data URI in the bundled output")
+	}
+}
+
+func TestBundleLocalRejectsTraversal(t *testing.T) {
+	fsys := fstest.MapFS{
+		"icon.png": &fstest.MapFile{Data: []byte("not a real png, just bytes")},
+	}
+
+	svg := []byte(`<g><image href="../../etc/passwd" x="0" y="0" /></g>`)
+	out := bundleLocal(testContext(), fsys, svg)
+
+	if !strings.Contains(string(out), `href="../../etc/passwd"`) {
+		t.Error("expected a traversal attempt to be left unresolved, not read")
+	}
+}
+
+func TestBundleLocalLeavesRemoteHrefs(t *testing.T) {
+	fsys := fstest.MapFS{}
+
+	svg := []byte(`<g><image href="https://example.com/icon.png" x="0" y="0" /></g>`)
+	out := bundleLocal(testContext(), fsys, svg)
+
+	if !strings.Contains(string(out), "https://example.com/icon.png") {
+		t.Error("expected a remote href to be left for imgbundler.BundleRemote, not touched here")
+	}
+}
+
+func TestLocalFSPath(t *testing.T) {
+	cases := []struct {
+		href string
+		ok   bool
+	}{
+		{"./diagrams/foo.png", true},
+		{"diagrams/foo.png", true},
+		{"../diagrams/foo.png", false},
+		{"../../etc/passwd", false},
+		{"/etc/passwd", false},
+	}
+
+	for _, c := range cases {
+		_, ok := localFSPath(c.href)
+		if ok != c.ok {
+			t.Errorf("localFSPath(%q) ok = %v, want %v", c.href, ok, c.ok)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"os"
+	"regexp"
+
+	"cdr.dev/slog"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/pdf"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// fontFaceRE strips @font-face blocks from D2's generated <style> CDATA
+// before handing the SVG to oksvg. oksvg can't load the embedded woff data
+// URIs anyway (it falls back to its built-in font for text), and its CSS
+// parser splits declarations on every ";" — which breaks on the
+// "data:application/font-woff;base64,..." URIs D2 embeds here.
+var fontFaceRE = regexp.MustCompile(`(?s)@font-face\s*\{.*?\}`)
+
+// maskRE strips D2's <mask> elements (used to carve link/tooltip icons out of
+// connection lines) before handing the SVG to oksvg. oksvg doesn't support
+// masking at all, and feeding it a <mask> element corrupts its parser state
+// for everything that follows, so the shapes it would have carved are left
+// un-carved in the rasterized PNG/PDF output instead.
+var maskRE = regexp.MustCompile(`(?s)<mask[^>]*>.*?</mask>`)
+
+// svgToPNG rasterizes svg with oksvg + rasterx rather than D2's own
+// Playwright-backed png.ConvertSVG, so PNG/PDF export doesn't need a headless
+// Chrome install alongside the mdbook plugin.
+func svgToPNG(svg []byte, scale float64) ([]byte, error) {
+	if scale <= 0 {
+		scale = 1
+	}
+	svg = fontFaceRE.ReplaceAll(svg, nil)
+	svg = maskRE.ReplaceAll(svg, nil)
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svg))
+	if err != nil {
+		return nil, err
+	}
+
+	width := int(icon.ViewBox.W * scale)
+	height := int(icon.ViewBox.H * scale)
+	icon.SetTarget(0, 0, float64(width), float64(height))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	raster := rasterx.NewDasher(width, height, scanner)
+	icon.Draw(raster, 1.0)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//export RenderPDF
+func RenderPDF(content string) *C.char {
+	return serializeResult(renderPDF(content))
+}
+
+// PDFResult is the response for RenderPDF. Pages lists the boards in the
+// order they were composited, one PDF page per entry, so a caller can build
+// a table of contents without re-walking the diagram tree.
+type PDFResult struct {
+	Content     string     `json:"content"`
+	ContentType string     `json:"contentType"`
+	Pages       []PDFPage  `json:"pages"`
+	Diagnostics []LogEntry `json:"diagnostics,omitempty"`
+}
+
+type PDFPage struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+func renderPDF(content string) (*PDFResult, error) {
+	sink := newDiagnosticsSink()
+	ctx := log.With(context.Background(), slog.Make(sink).Leveled(currentLogLevel()))
+	ruler, err := textmeasure.NewRuler()
+	if err != nil {
+		return nil, err
+	}
+
+	renderOpts := &d2svg.RenderOpts{}
+	diagram, _, err := d2lib.Compile(ctx, content,
+		&d2lib.CompileOptions{
+			LayoutResolver: layoutResolver,
+			Ruler:          ruler,
+		},
+		renderOpts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := pdf.Init()
+	var pages []PDFPage
+	if err := addPDFPages(doc, diagram, renderOpts, &pages); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "d2-sys-*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	if err := doc.Export(tmp.Name()); err != nil {
+		return nil, err
+	}
+	pdfBytes, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return &PDFResult{
+		Content:     base64.StdEncoding.EncodeToString(pdfBytes),
+		ContentType: ContentTypePDF,
+		Pages:       pages,
+		Diagnostics: sink.snapshot(),
+	}, nil
+}
+
+// addPDFPages walks diagram's nested boards in the same order renderRecursive
+// uses for layers/scenarios/steps, adding one PDF page per rendered board and
+// recording its name and page index so consumers can build a table of
+// contents.
+func addPDFPages(doc *pdf.GoFPDF, diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, pages *[]PDFPage) error {
+	if !diagram.IsFolderOnly {
+		rootFill := diagram.Root.Fill
+		// gofpdf renders the embedded PNG with a slight filter; keep the page's own
+		// background fill as the only bg color by making the rasterized one transparent.
+		// "none" rather than "transparent": oksvg's color parser doesn't recognize
+		// the "transparent" keyword and corrupts its parser state on it, breaking
+		// every path parsed afterwards.
+		diagram.Root.Fill = "none"
+		svg, err := d2svg.Render(diagram, renderOpts)
+		diagram.Root.Fill = rootFill
+		if err != nil {
+			return err
+		}
+
+		// AddPDFPage halves the embedded image's dimensions, mirroring the 2x
+		// scale D2's own Playwright rasterizer produces.
+		png, err := svgToPNG(svg, 2)
+		if err != nil {
+			return err
+		}
+
+		pad := int64(d2svg.DEFAULT_PADDING)
+		if renderOpts.Pad != nil {
+			pad = *renderOpts.Pad
+		}
+		themeID := d2themescatalog.NeutralDefault.ID
+		if renderOpts.ThemeID != nil {
+			themeID = *renderOpts.ThemeID
+		}
+		tl, _ := diagram.BoundingBox()
+
+		err = doc.AddPDFPage(png, []pdf.BoardTitle{{Name: diagram.Root.Label}}, themeID, rootFill, diagram.Shapes, pad,
+			float64(tl.X)-float64(pad), float64(tl.Y)-float64(pad), map[string]int{}, false)
+		if err != nil {
+			return err
+		}
+
+		*pages = append(*pages, PDFPage{Name: diagram.Name, Index: len(*pages)})
+	}
+
+	for _, l := range diagram.Layers {
+		if err := addPDFPages(doc, l, renderOpts, pages); err != nil {
+			return err
+		}
+	}
+	for _, s := range diagram.Scenarios {
+		if err := addPDFPages(doc, s, renderOpts, pages); err != nil {
+			return err
+		}
+	}
+	for _, s := range diagram.Steps {
+		if err := addPDFPages(doc, s, renderOpts, pages); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
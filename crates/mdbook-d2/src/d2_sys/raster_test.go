@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+const testDiagram = `x -> y`
+
+func TestRenderPNG(t *testing.T) {
+	result, err := renderPNG(testDiagram, 1)
+	if err != nil {
+		t.Fatalf("renderPNG failed: %v", err)
+	}
+	if result.ContentType != ContentTypePNG {
+		t.Errorf("expected contentType %q, got %q", ContentTypePNG, result.ContentType)
+	}
+	if result.Content == "" {
+		t.Error("expected non-empty PNG content")
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	result, err := renderPDF(testDiagram)
+	if err != nil {
+		t.Fatalf("renderPDF failed: %v", err)
+	}
+	if result.ContentType != ContentTypePDF {
+		t.Errorf("expected contentType %q, got %q", ContentTypePDF, result.ContentType)
+	}
+	if len(result.Pages) != 1 {
+		t.Errorf("expected 1 page, got %d", len(result.Pages))
+	}
+}